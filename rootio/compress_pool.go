@@ -0,0 +1,569 @@
+// Copyright 2017 The go-hep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rootio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// CompressionAlgo identifies a basket (de)compression algorithm.
+type CompressionAlgo int
+
+// Supported compression algorithms.
+const (
+	CompressZlib CompressionAlgo = iota + 1
+	CompressLZ4
+	CompressZstd
+)
+
+// Codec compresses and decompresses TBasket payloads. New codecs can be
+// registered with RegisterCodec, the same way archive/zip's
+// RegisterCompressor lets callers plug in new methods.
+type Codec interface {
+	Compress(level int, src []byte) ([]byte, error)
+	Decompress(dst, src []byte) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[CompressionAlgo]Codec{}
+)
+
+// RegisterCodec registers a Codec for algo, overriding any previously
+// registered codec for the same algorithm.
+func RegisterCodec(algo CompressionAlgo, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[algo] = codec
+}
+
+func codecFor(algo CompressionAlgo) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[algo]
+	if !ok {
+		return nil, fmt.Errorf("rootio: no codec registered for algorithm %d", algo)
+	}
+	return codec, nil
+}
+
+// CompressionJob is a unit of work submitted to a CompressionPool: the
+// uncompressed payload of the TBasket that will be written at seekKey.
+type CompressionJob struct {
+	SeekKey int64
+	Data    []byte
+}
+
+// CompressionResult is the outcome of a CompressionJob once it has gone
+// through the pool.
+type CompressionResult struct {
+	SeekKey    int64
+	Compressed []byte
+	Checksum   uint32
+	Err        error
+}
+
+// CompressionPool compresses (or decompresses) TBasket payloads across
+// nworkers goroutines while preserving the order in which jobs were
+// submitted, so that writeHeader/key-record emission can stay
+// sequential even though the CPU-heavy (de)compression work happens in
+// parallel.
+//
+// A CompressionPool is meant to be driven by a single submitter
+// goroutine that also drains Results(): the ordering guarantee is
+// between submission order and delivery order on that one channel, not
+// between concurrent callers of Submit/Results. Sharing one pool
+// between two unrelated writer goroutines would let one goroutine's
+// read off Results() consume a result meant for the other. See
+// File.WriteBaskets for the pattern this supports: keep up to nworkers
+// jobs submitted ahead, and drain Results() one at a time to perform the
+// (still sequential) disk writes, submitting the next job as each result
+// is taken. Submitting many more jobs than that before draining any
+// result blocks Submit once the pool's bounded channels are full.
+type CompressionPool struct {
+	algo  CompressionAlgo
+	level int
+	codec Codec
+
+	in  chan compressionTask
+	out chan CompressionResult
+	seq int64 // next submission index handed out by Submit
+
+	wg sync.WaitGroup
+}
+
+type compressionTask struct {
+	job CompressionJob
+	idx int
+}
+
+// NewCompressionPool creates a CompressionPool of nworkers goroutines
+// compressing with algo at the given level (algorithm-specific; ignored
+// by codecs that don't support it).
+func NewCompressionPool(nworkers int, algo CompressionAlgo, level int) (*CompressionPool, error) {
+	if nworkers <= 0 {
+		nworkers = 1
+	}
+	codec, err := codecFor(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &CompressionPool{
+		algo:  algo,
+		level: level,
+		codec: codec,
+		in:    make(chan compressionTask, nworkers),
+		out:   make(chan CompressionResult, nworkers),
+	}
+
+	// ordered re-sequencing: each worker writes its result keyed by
+	// submission index into a slot map, and a single goroutine drains
+	// the slots in order onto p.out.
+	results := make(chan struct {
+		idx int
+		res CompressionResult
+	}, nworkers)
+
+	for i := 0; i < nworkers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for t := range p.in {
+				compressed, err := p.codec.Compress(p.level, t.job.Data)
+				res := CompressionResult{SeekKey: t.job.SeekKey, Err: err}
+				if err == nil {
+					res.Compressed = compressed
+					res.Checksum = crc32.ChecksumIEEE(compressed)
+				}
+				results <- struct {
+					idx int
+					res CompressionResult
+				}{t.idx, res}
+			}
+		}()
+	}
+
+	go func() {
+		p.wg.Wait()
+		close(results)
+	}()
+
+	go func() {
+		defer close(p.out)
+		pending := make(map[int]CompressionResult)
+		next := 0
+		for r := range results {
+			pending[r.idx] = r.res
+			for {
+				res, ok := pending[next]
+				if !ok {
+					break
+				}
+				p.out <- res
+				delete(pending, next)
+				next++
+			}
+		}
+	}()
+
+	return p, nil
+}
+
+// Submit enqueues job for compression. It must not be called after
+// Close.
+//
+// Jobs are tagged with a monotonically increasing submission index so
+// that, however the nworkers goroutines finish them, Results can
+// re-sequence the CompressionResults back into submission order.
+func (p *CompressionPool) Submit(job CompressionJob) {
+	idx := int(atomic.AddInt64(&p.seq, 1) - 1)
+	p.in <- compressionTask{job: job, idx: idx}
+}
+
+// Results returns the channel of CompressionResults, delivered in the
+// same order jobs were submitted via Submit.
+func (p *CompressionPool) Results() <-chan CompressionResult {
+	return p.out
+}
+
+// Close signals that no more jobs will be submitted. It returns without
+// waiting; Results is closed once every job submitted before Close has
+// been delivered on it.
+func (p *CompressionPool) Close() {
+	close(p.in)
+}
+
+// WithCompressionPool configures Create to compress TBasket payloads
+// using pool instead of compressing them inline on the writer's
+// goroutine.
+func WithCompressionPool(pool *CompressionPool) FileOption {
+	return func(f *File) {
+		f.cpool = pool
+	}
+}
+
+// FileOption configures a *File created by Create.
+type FileOption func(*File)
+
+// PrefetchReader looks ahead K baskets on the current branch while a
+// TTree is being iterated, decompressing up to K of them concurrently
+// across a pool of background goroutines (one per unit of look-ahead)
+// and handing already-decompressed buffers to the deserializer as they
+// are consumed, instead of decompressing baskets synchronously, one at
+// a time, on the iteration goroutine. Each worker decodes a basket via
+// Key.Open, so it goes through the same Codec/RegisterCodec dispatch
+// (see section.go) as everything else that reads a key.
+type PrefetchReader struct {
+	keys  []Key
+	ahead int
+
+	mu      sync.Mutex
+	claimed int // index of the next key not yet claimed by a worker
+	next    int // lowest index not yet delivered to Next()
+	buf     map[int][]byte
+	errs    map[int]error
+	cond    *sync.Cond
+	closed  bool
+}
+
+// NewPrefetchReader returns a PrefetchReader that decompresses up to
+// ahead baskets from keys concurrently, in the background. Callers that
+// stop iterating before exhausting keys (an early break, an error, a
+// LIMIT) must call Close, or the background workers leak.
+func NewPrefetchReader(keys []Key, ahead int) *PrefetchReader {
+	if ahead <= 0 {
+		ahead = 1
+	}
+	r := &PrefetchReader{
+		keys:  keys,
+		ahead: ahead,
+		buf:   make(map[int][]byte),
+		errs:  make(map[int]error),
+	}
+	r.cond = sync.NewCond(&r.mu)
+	for i := 0; i < ahead; i++ {
+		go r.worker()
+	}
+	return r
+}
+
+// worker claims and decodes keys, one at a time, until it runs out of
+// keys within the current look-ahead window or the reader is closed.
+// Running ahead of these workers decode baskets [next, next+ahead)
+// concurrently, bounded by ahead just like a single-goroutine read-ahead
+// would be bounded by its look-ahead distance.
+func (r *PrefetchReader) worker() {
+	for {
+		r.mu.Lock()
+		for !r.closed && r.claimed < len(r.keys) && r.claimed-r.next >= r.ahead {
+			r.cond.Wait()
+		}
+		if r.closed || r.claimed >= len(r.keys) {
+			r.mu.Unlock()
+			return
+		}
+		i := r.claimed
+		k := r.keys[i]
+		r.claimed++
+		r.mu.Unlock()
+
+		raw, err := decodeBasket(k)
+
+		r.mu.Lock()
+		if err != nil {
+			r.errs[i] = err
+		} else {
+			r.buf[i] = raw
+		}
+		r.cond.Broadcast()
+		r.mu.Unlock()
+	}
+}
+
+// decodeBasket opens and fully reads k's (decompressed) payload.
+func decodeBasket(k Key) ([]byte, error) {
+	rc, err := k.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// Next blocks until basket i has been decompressed and returns its
+// payload.
+func (r *PrefetchReader) Next(i int) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		if err, ok := r.errs[i]; ok {
+			return nil, err
+		}
+		if buf, ok := r.buf[i]; ok {
+			delete(r.buf, i)
+			if i >= r.next {
+				r.next = i + 1
+			}
+			r.cond.Broadcast()
+			return buf, nil
+		}
+		if r.closed {
+			return nil, fmt.Errorf("rootio: prefetch reader closed before basket %d was decompressed", i)
+		}
+		r.cond.Wait()
+	}
+}
+
+// Close stops the background worker goroutines. It must be called
+// once the caller is done consuming baskets, whether or not all of keys
+// was read, otherwise the workers stay blocked in r.cond.Wait forever.
+func (r *PrefetchReader) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.cond.Broadcast()
+	r.mu.Unlock()
+	return nil
+}
+
+// zlibCodec is the built-in, always-registered zlib codec.
+type zlibCodec struct{}
+
+func (zlibCodec) Compress(level int, src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := zlib.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (zlibCodec) Decompress(dst, src []byte) error {
+	r, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.ReadFull(r, dst)
+	return err
+}
+
+var _ Codec = zlibCodec{}
+
+// lz4Codec is the built-in, always-registered LZ4 codec.
+type lz4Codec struct{}
+
+func (lz4Codec) Compress(level int, src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := lz4.NewWriter(&buf)
+	if err := w.Apply(lz4.CompressionLevelOption(lz4.Level(level))); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (lz4Codec) Decompress(dst, src []byte) error {
+	r := lz4.NewReader(bytes.NewReader(src))
+	_, err := io.ReadFull(r, dst)
+	return err
+}
+
+var _ Codec = lz4Codec{}
+
+// zstdCodec is the built-in, always-registered zstd codec.
+type zstdCodec struct{}
+
+func (zstdCodec) Compress(level int, src []byte) ([]byte, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+	return enc.EncodeAll(src, nil), nil
+}
+
+func (zstdCodec) Decompress(dst, src []byte) error {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	out, err := dec.DecodeAll(src, dst[:0])
+	if err != nil {
+		return err
+	}
+	if len(out) != len(dst) {
+		return fmt.Errorf("rootio: zstd decompressed %d bytes, expected %d", len(out), len(dst))
+	}
+	copy(dst, out)
+	return nil
+}
+
+var _ Codec = zstdCodec{}
+
+func init() {
+	RegisterCodec(CompressZlib, zlibCodec{})
+	RegisterCodec(CompressLZ4, lz4Codec{})
+	RegisterCodec(CompressZstd, zstdCodec{})
+}
+
+// compressInline compresses data through the codec registered for
+// f.calgo (defaulting to zlib) on the calling goroutine, without going
+// through a CompressionPool. It is used by WriteBaskets when f has no
+// CompressionPool configured via WithCompressionPool.
+func (f *File) compressInline(data []byte) ([]byte, uint32, error) {
+	algo := f.calgo
+	if algo == 0 {
+		algo = CompressZlib
+	}
+	codec, err := codecFor(algo)
+	if err != nil {
+		return nil, 0, err
+	}
+	compressed, err := codec.Compress(f.clevel, data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return compressed, crc32.ChecksumIEEE(compressed), nil
+}
+
+// WithCompressionAlgo sets the codec (and, for codecs that support it,
+// the compression level) used to compress TBasket payloads when no
+// CompressionPool is configured via WithCompressionPool.
+func WithCompressionAlgo(algo CompressionAlgo, level int) FileOption {
+	return func(f *File) {
+		f.calgo = algo
+		f.clevel = level
+	}
+}
+
+// writeCompressed stores the already-compressed basket compressed in
+// the best-fitting free block (see File.best), falling back to growing
+// the file if none fits, and returns the seek position it was written
+// at.
+func (f *File) writeCompressed(compressed []byte) (seekKey int64, err error) {
+	need := int64(len(compressed))
+	blk := f.best(int32(need))
+	seekKey = blk.first
+
+	if tail := blk.last - blk.first + 1 - need; tail > 0 {
+		// best() splits an oversized free block itself and returns
+		// exactly need bytes, so tail is 0 for every block it hands
+		// back; any positive tail would be reclaimed instead of leaked.
+		f.reclaim(seekKey+need, blk.last)
+	}
+
+	if _, err := f.w.WriteAt(compressed, seekKey); err != nil {
+		return 0, fmt.Errorf("rootio: could not write basket at %d: %w", seekKey, err)
+	}
+	if end := seekKey + need; end > f.end {
+		f.end = end
+	}
+	return seekKey, nil
+}
+
+// WrittenBasket is the on-disk location a basket was stored at by
+// WriteBasket or WriteBaskets.
+type WrittenBasket struct {
+	SeekKey  int64
+	Checksum uint32
+}
+
+// WriteBasket compresses data (the uncompressed payload of a TBasket)
+// and stores it in the best-fitting free block. It is equivalent to
+// calling WriteBaskets with a single basket; callers writing several
+// baskets back to back should prefer WriteBaskets, which pipelines
+// compression ahead of the (still sequential) disk writes when f was
+// created with WithCompressionPool.
+func (f *File) WriteBasket(data []byte) (seekKey int64, checksum uint32, err error) {
+	out, err := f.WriteBaskets([][]byte{data})
+	if err != nil {
+		return 0, 0, err
+	}
+	return out[0].SeekKey, out[0].Checksum, nil
+}
+
+// WriteBaskets compresses each of datas and stores it in the
+// best-fitting free block, returning one WrittenBasket per input, in the
+// same order.
+//
+// Disk writes always happen sequentially, in submission order, through
+// File.best/File.reclaim. When f has no CompressionPool, compression is
+// just as sequential, one basket at a time on the calling goroutine.
+// When f was created with WithCompressionPool, up to nworkers jobs are
+// kept submitted ahead of the writes so the pool's goroutines can
+// compress them concurrently; WriteBaskets drains
+// CompressionPool.Results() - already delivered in submission order -
+// one at a time to perform the writes, submitting the next job as each
+// result is taken, so basket i+1 (and beyond, up to the pool's worker
+// count) is compressed concurrently with the disk write of basket i.
+func (f *File) WriteBaskets(datas [][]byte) ([]WrittenBasket, error) {
+	if f.w == nil {
+		return nil, fmt.Errorf("rootio: file %q was not opened for writing", f.id)
+	}
+
+	out := make([]WrittenBasket, len(datas))
+
+	if f.cpool == nil {
+		for i, data := range datas {
+			compressed, checksum, err := f.compressInline(data)
+			if err != nil {
+				return nil, fmt.Errorf("rootio: could not compress basket %d: %w", i, err)
+			}
+			seekKey, err := f.writeCompressed(compressed)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = WrittenBasket{SeekKey: seekKey, Checksum: checksum}
+		}
+		return out, nil
+	}
+
+	// the pool's channels are bounded: keep at most nworkers jobs in
+	// flight so that Submit never blocks waiting for a result that
+	// nobody is reading yet.
+	window := cap(f.cpool.in)
+	next := 0
+	for ; next < len(datas) && next < window; next++ {
+		f.cpool.Submit(CompressionJob{SeekKey: int64(next), Data: datas[next]})
+	}
+	for i := range datas {
+		res := <-f.cpool.Results()
+		if next < len(datas) {
+			f.cpool.Submit(CompressionJob{SeekKey: int64(next), Data: datas[next]})
+			next++
+		}
+		if res.Err != nil {
+			return nil, fmt.Errorf("rootio: could not compress basket %d: %w", i, res.Err)
+		}
+		seekKey, err := f.writeCompressed(res.Compressed)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = WrittenBasket{SeekKey: seekKey, Checksum: res.Checksum}
+	}
+	return out, nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 The go-hep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rootio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memWriter is a growable in-memory Writer, for tests that need a *File
+// opened for writing without touching disk.
+type memWriter struct {
+	mu   sync.Mutex
+	data []byte
+	off  int64
+}
+
+func (w *memWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(w.data)) {
+		grown := make([]byte, end)
+		copy(grown, w.data)
+		w.data = grown
+	}
+	copy(w.data[off:], p)
+	return len(p), nil
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+func (w *memWriter) Seek(offset int64, whence int) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+		w.off = offset
+	case io.SeekCurrent:
+		w.off += offset
+	case io.SeekEnd:
+		w.off = int64(len(w.data)) + offset
+	}
+	return w.off, nil
+}
+
+func (w *memWriter) Close() error { return nil }
+
+var _ Writer = (*memWriter)(nil)
+
+// TestCompressionPoolOrdering checks that CompressionResults come out
+// of Results in submission order, even though nworkers goroutines race
+// to finish the underlying compression.
+func TestCompressionPoolOrdering(t *testing.T) {
+	pool, err := NewCompressionPool(8, CompressZlib, zlib.BestSpeed)
+	if err != nil {
+		t.Fatalf("NewCompressionPool: %v", err)
+	}
+
+	const n = 200
+	go func() {
+		for i := 0; i < n; i++ {
+			pool.Submit(CompressionJob{SeekKey: int64(i), Data: []byte(fmt.Sprintf("basket-%04d", i))})
+		}
+		pool.Close()
+	}()
+
+	got := 0
+	for res := range pool.Results() {
+		if res.Err != nil {
+			t.Fatalf("job %d: unexpected error: %v", got, res.Err)
+		}
+		if res.SeekKey != int64(got) {
+			t.Fatalf("result %d delivered out of order: got seekKey=%d, want %d", got, res.SeekKey, got)
+		}
+		got++
+	}
+	if got != n {
+		t.Fatalf("got %d results, want %d", got, n)
+	}
+}
+
+// TestWriteBasketsPipeline checks that WriteBaskets, backed by a
+// CompressionPool, writes every basket to the correct, distinct
+// location and in the right order, even though the pool compresses
+// several of them concurrently ahead of the sequential disk writes.
+func TestWriteBasketsPipeline(t *testing.T) {
+	pool, err := NewCompressionPool(4, CompressZlib, zlib.BestSpeed)
+	if err != nil {
+		t.Fatalf("NewCompressionPool: %v", err)
+	}
+	defer pool.Close()
+
+	w := &memWriter{}
+	f := &File{w: w}
+	WithCompressionPool(pool)(f)
+
+	const n = 20
+	datas := make([][]byte, n)
+	for i := range datas {
+		datas[i] = bytes.Repeat([]byte{byte(i)}, 4096+i)
+	}
+
+	written, err := f.WriteBaskets(datas)
+	if err != nil {
+		t.Fatalf("WriteBaskets: %v", err)
+	}
+	if len(written) != n {
+		t.Fatalf("got %d written baskets, want %d", len(written), n)
+	}
+
+	for i, wb := range written {
+		// zlib streams are self-delimiting, so reading from seekKey
+		// onward (even though basket i+1 immediately follows in w.data)
+		// decodes exactly basket i's payload.
+		zr, err := zlib.NewReader(bytes.NewReader(w.data[wb.SeekKey:]))
+		if err != nil {
+			t.Fatalf("basket %d: zlib.NewReader: %v", i, err)
+		}
+		got, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("basket %d: ReadAll: %v", i, err)
+		}
+		if !bytes.Equal(got, datas[i]) {
+			t.Fatalf("basket %d: payload mismatch after round-trip", i)
+		}
+	}
+}
+
+// TestPrefetchReaderClose checks that Close unblocks a goroutine parked
+// in Next on a basket that will never be produced.
+func TestPrefetchReaderClose(t *testing.T) {
+	keys := make([]Key, 1000)
+	r := NewPrefetchReader(keys, 1)
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = r.Next(len(keys) - 1) // far outside the prefetch window
+		close(done)
+	}()
+
+	// give Next a chance to actually block on r.cond.Wait.
+	time.Sleep(10 * time.Millisecond)
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Next did not return after Close: background goroutine leaked")
+	}
+}
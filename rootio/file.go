@@ -67,6 +67,14 @@ type Writer interface {
 //    38->41 [46->53] fSeekInfo   = Pointer to TStreamerInfo record
 //    42->45 [54->57] fNbytesInfo = Number of bytes in TStreamerInfo record
 //    46->63 [58->75] fUUID       = Universal Unique ID
+//
+// Keys decoded through Key.Open are safe to read concurrently, from the
+// same or different goroutines: Open is built on SectionReader, which
+// only ever calls ReadAt on the underlying io.ReaderAt and never touches
+// a shared cursor. File.readHeader and File.readStreamerInfo are
+// likewise ReadAt-based. The embedded Seek cursor exposed by
+// File.Seek/File.Tell is not safe for concurrent use; prefer
+// SectionReader or Key.Open when decoding keys from multiple goroutines.
 type File struct {
 	r      Reader
 	w      Writer
@@ -95,6 +103,10 @@ type File struct {
 	sinfos []StreamerInfo
 
 	blocks blocks // blocks is a list of free blocks in a ROOT file.
+
+	cpool  *CompressionPool // optional pool used to compress TBasket payloads concurrently
+	calgo  CompressionAlgo  // codec used by WriteBasket when cpool is nil; defaults to CompressZlib
+	clevel int              // compression level passed to calgo's codec
 }
 
 // Open opens the named ROOT file for reading. If successful, methods on the
@@ -141,7 +153,7 @@ func NewReader(r Reader, name string) (*File, error) {
 }
 
 // Create creates the named ROOT file for writing.
-func Create(name string) (*File, error) {
+func Create(name string, opts ...FileOption) (*File, error) {
 	fd, err := os.Create(name)
 	if err != nil {
 		return nil, fmt.Errorf("rootio: unable to create %q (%q)", name, err.Error())
@@ -153,6 +165,9 @@ func Create(name string) (*File, error) {
 		closer: fd,
 		id:     name,
 	}
+	for _, opt := range opts {
+		opt(f)
+	}
 	f.dir = tdirectory{named: tnamed{name: name}, file: f}
 
 	err = f.writeHeader()
@@ -251,6 +266,11 @@ func (f *File) readHeader() error {
 		return fmt.Errorf("rootio: failed to read ROOT file keys: %v", err)
 	}
 
+	err = f.readFreeSegments()
+	if err != nil {
+		return fmt.Errorf("rootio: failed to read ROOT free segments list: %v", err)
+	}
+
 	return nil
 }
 
@@ -360,6 +380,14 @@ func (f *File) Tell() int64 {
 // Close closes the File, rendering it unusable for I/O.
 // It returns an error, if any.
 func (f *File) Close() error {
+	if f.cpool != nil {
+		f.cpool.Close()
+	}
+	if f.w != nil {
+		if err := f.writeFreeSegments(); err != nil {
+			return fmt.Errorf("rootio: failed to write ROOT free segments list: %v", err)
+		}
+	}
 	for _, k := range f.dir.keys {
 		k.f = nil
 	}
@@ -446,24 +474,34 @@ type block struct {
 // blocks is a list of free blocks in a ROOT file.
 type blocks []block
 
+// add records [first,last] as free, coalescing it with any block it is
+// adjacent to on either side (so that reclaiming the extent of an
+// overwritten cycle merges it back into the surrounding free space
+// instead of fragmenting it).
 func (blks *blocks) add(first, last int64) int {
 	for i := range *blks {
 		blk := &(*blks)[i]
 		if blk.last == first-1 {
 			blk.last = last
-			if i+1 >= len(*blks) {
-				return i
-			}
-			next := &(*blks)[i+1]
-			if next.first > last+1 {
-				return i
+			if i+1 < len(*blks) {
+				next := &(*blks)[i+1]
+				if next.first == last+1 {
+					blk.last = next.last
+					*blks = append((*blks)[:i+1], (*blks)[i+2:]...)
+				}
 			}
-			blk.last = next.last
-			(*blks) = append((*blks)[:i+1], (*blks)[i+2:]...)
 			return i
 		}
 		if blk.first == last+1 {
 			blk.first = first
+			if i > 0 {
+				prev := &(*blks)[i-1]
+				if prev.last == first-1 {
+					prev.last = blk.last
+					*blks = append((*blks)[:i], (*blks)[i+1:]...)
+					return i - 1
+				}
+			}
 			return i
 		}
 		if first < blk.first {
@@ -472,35 +510,9 @@ func (blks *blocks) add(first, last int64) int {
 			return i
 		}
 	}
-	return -1
-}
-
-// best returns the best free block where to store nbytes.
-func (blks blocks) best(nbytes int32) *block {
-	var blk *block
-	for i := range blks {
-		cur := &blks[i]
-		nleft := cur.last - cur.first + 1
-		if nleft == int64(nbytes) {
-			// found an exact match
-			return cur
-		}
-		if nleft > int64(nbytes+3) {
-			if blk == nil {
-				blk = cur
-			}
-		}
-	}
-
-	// return first segment that can contain 'nbytes'
-	if blk != nil {
-		return blk
-	}
-
-	// try big file
-	blk = &blks[len(blks)-1]
-	blk.last += 1000000000
-	return blk
+	// new block lies entirely past every existing one: append it.
+	*blks = append(*blks, block{first, last})
+	return len(*blks) - 1
 }
 
 func (blks *blocks) remove(blk *block) {
@@ -0,0 +1,206 @@
+// Copyright 2017 The go-hep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rootio
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// tkeyMinLen is a conservative lower bound on the on-disk size of a
+// TKey header (fixed fields plus short class/name/title strings). A
+// free block is only worth splitting if what remains after carving out
+// nbytes could itself hold a TKey, otherwise the leftover sliver can
+// never be reused.
+const tkeyMinLen = 64
+
+// best returns a free block able to hold nbytes, in TFile's own
+// best-fit order: an exact match first, then the first block with
+// enough slack to be split (the tail is reinserted as a new free
+// block), and only if neither exists, a fresh block grown at the end of
+// the file.
+func (f *File) best(nbytes int32) block {
+	need := int64(nbytes)
+
+	for i, cur := range f.blocks {
+		if cur.last-cur.first+1 == need {
+			f.blocks.remove(&f.blocks[i])
+			return cur
+		}
+	}
+
+	for i := range f.blocks {
+		cur := &f.blocks[i]
+		if cur.last-cur.first+1 >= need+tkeyMinLen {
+			alloc := block{first: cur.first, last: cur.first + need - 1}
+			cur.first = alloc.last + 1
+			return alloc
+		}
+	}
+
+	alloc := block{first: f.end, last: f.end + need - 1}
+	f.end = alloc.last + 1
+	return alloc
+}
+
+// reclaim marks the on-disk extent [first,last] as free again, merging
+// it with any free block it happens to be adjacent to instead of
+// fragmenting the free list. It is what a key deletion, or a new cycle
+// superseding an old one, should call with the old key's extent;
+// writeCompressed also calls it for any part of the block returned by
+// best that a basket does not fill (best already keeps the tail of a
+// block it splits in the free list).
+func (f *File) reclaim(first, last int64) {
+	f.blocks.add(first, last)
+}
+
+// readFreeSegments reads the TFree record at f.seekfree, if any, and
+// populates f.blocks with the free blocks it describes.
+func (f *File) readFreeSegments() error {
+	if f.seekfree <= 0 {
+		return nil
+	}
+
+	buf := make([]byte, f.nbytesfree)
+	n, err := f.ReadAt(buf, f.seekfree)
+	if err != nil {
+		return err
+	}
+	if n != len(buf) {
+		return fmt.Errorf("rootio: requested [%v] bytes. read [%v] bytes from file", len(buf), n)
+	}
+
+	w := int(f.units)
+	if w == 0 {
+		w = 4
+	}
+	entry := 2 + 2*w // TFree version (i16) + first + last, each w bytes wide
+
+	var blks blocks
+	for off := 0; off+entry <= len(buf); off += entry {
+		b := buf[off+2:] // skip the per-entry TFree version
+		var blk block
+		if w == 4 {
+			blk.first = int64(int32(binary.BigEndian.Uint32(b[0:4])))
+			blk.last = int64(int32(binary.BigEndian.Uint32(b[4:8])))
+		} else {
+			blk.first = int64(binary.BigEndian.Uint64(b[0:8]))
+			blk.last = int64(binary.BigEndian.Uint64(b[8:16]))
+		}
+		blks = append(blks, blk)
+	}
+
+	f.blocks = blks
+	return nil
+}
+
+// writeFreeSegments serializes f.blocks as a TFree record, appends it
+// past the current end of the file, updates f.seekfree/f.nbytesfree
+// accordingly, and rewrites the fixed-size file header so readers
+// opening the file afterwards find it.
+func (f *File) writeFreeSegments() error {
+	w := int(f.units)
+	if w == 0 {
+		w = 4
+	}
+	entry := 2 + 2*w
+
+	buf := make([]byte, 0, entry*len(f.blocks))
+	for _, blk := range f.blocks {
+		e := make([]byte, entry)
+		binary.BigEndian.PutUint16(e[0:2], 1) // TFree class version
+		if w == 4 {
+			binary.BigEndian.PutUint32(e[2:6], uint32(blk.first))
+			binary.BigEndian.PutUint32(e[6:10], uint32(blk.last))
+		} else {
+			binary.BigEndian.PutUint64(e[2:10], uint64(blk.first))
+			binary.BigEndian.PutUint64(e[10:18], uint64(blk.last))
+		}
+		buf = append(buf, e...)
+	}
+
+	k := newHeaderKey("TFree", "", "TFree", f, int32(len(buf)))
+	wkey := NewWBuffer(k.buf, nil, 0)
+	wkey.write(buf)
+	if err := k.writeFile(); err != nil {
+		return err
+	}
+
+	f.seekfree = k.seekkey
+	f.nbytesfree = k.bytes
+	// f.blocks has kept changing (best/reclaim) since f.nfree was last
+	// set at file creation; reflect the free list as it stands now, or
+	// the header would claim a free-block count that no longer matches
+	// what's actually written below.
+	f.nfree = int32(len(f.blocks))
+
+	return f.rewriteHeader()
+}
+
+// headerTailOffset is the byte offset, within the fixed-size file
+// header, of fEND: 4 bytes of "root" magic, 4 bytes of fVersion and 4
+// bytes of fBEGIN, none of which ever change once the file has been
+// created.
+const headerTailOffset = 12
+
+// rewriteHeader re-serializes the part of the fixed-size file header
+// that changes as the file is mutated (fEND, fSeekFree, fNbytesFree,
+// ... through fUUID), reflecting the current values of f.end,
+// f.seekfree and f.nbytesfree. It is used by writeFreeSegments once the
+// free block list has been (re)written, since its location and size are
+// only known after that record has been laid out.
+func (f *File) rewriteHeader() error {
+	e := binary.BigEndian
+	var buf []byte
+
+	if f.version < 1000000 {
+		buf = make([]byte, 4+4+4+4+4+1+4+4+4+len(f.uuid))
+		i := 0
+		e.PutUint32(buf[i:], uint32(f.end))
+		i += 4
+		e.PutUint32(buf[i:], uint32(f.seekfree))
+		i += 4
+		e.PutUint32(buf[i:], uint32(f.nbytesfree))
+		i += 4
+		e.PutUint32(buf[i:], uint32(f.nfree))
+		i += 4
+		e.PutUint32(buf[i:], uint32(f.nbytesname))
+		i += 4
+		buf[i] = f.units
+		i++
+		e.PutUint32(buf[i:], uint32(f.compression))
+		i += 4
+		e.PutUint32(buf[i:], uint32(f.seekinfo))
+		i += 4
+		e.PutUint32(buf[i:], uint32(f.nbytesinfo))
+		i += 4
+		copy(buf[i:], f.uuid[:])
+	} else {
+		buf = make([]byte, 8+8+4+4+4+1+4+8+4+len(f.uuid))
+		i := 0
+		e.PutUint64(buf[i:], uint64(f.end))
+		i += 8
+		e.PutUint64(buf[i:], uint64(f.seekfree))
+		i += 8
+		e.PutUint32(buf[i:], uint32(f.nbytesfree))
+		i += 4
+		e.PutUint32(buf[i:], uint32(f.nfree))
+		i += 4
+		e.PutUint32(buf[i:], uint32(f.nbytesname))
+		i += 4
+		buf[i] = f.units
+		i++
+		e.PutUint32(buf[i:], uint32(f.compression))
+		i += 4
+		e.PutUint64(buf[i:], uint64(f.seekinfo))
+		i += 8
+		e.PutUint32(buf[i:], uint32(f.nbytesinfo))
+		i += 4
+		copy(buf[i:], f.uuid[:])
+	}
+
+	_, err := f.w.WriteAt(buf, headerTailOffset)
+	return err
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 The go-hep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rootio
+
+import (
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// isDirClass reports whether class is the class ROOT uses for an
+// embedded subdirectory. On disk these are written as TDirectoryFile;
+// TDirectory is kept as a fallback for the in-memory/streamer-only
+// representation some callers construct directly.
+func isDirClass(class string) bool {
+	return class == "TDirectoryFile" || class == "TDirectory"
+}
+
+// FS returns a io/fs.FS view of this file's directory hierarchy.
+//
+// Every Key (including those held by nested TDirectoryFile keys) is
+// exposed as a regular fs.File: Stat reports the key's class name,
+// cycle, uncompressed size and the time the key was written, and Read
+// streams the decompressed object payload. Keys whose class is a
+// directory (see isDirClass) are exposed as directories implementing
+// fs.ReadDirFile, so the returned fs.FS can be walked with fs.WalkDir,
+// queried with fs.Glob, and restricted with fs.Sub.
+func (f *File) FS() fs.FS {
+	return &keyFS{dir: &f.dir}
+}
+
+// keyDir is the minimal set of operations fs.go needs from a ROOT
+// directory to walk its keys. tdirectory already satisfies it.
+type keyDir interface {
+	Get(namecycle string) (Object, error)
+	keyList() []Key
+}
+
+func (d *tdirectory) keyList() []Key { return d.keys }
+
+// keyFS adapts a keyDir to fs.FS.
+type keyFS struct {
+	dir keyDir
+}
+
+func (kfs *keyFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return &fsDir{name: ".", entries: kfs.dir.keyList()}, nil
+	}
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	// Walk the path one directory level at a time, since a Key found at
+	// one level only tells us about its own directory's keys: resolving
+	// "a/b/c" requires Get-ing into "a", then "b", not a single flat
+	// Get("a/b/c") against the top-level directory's own key list.
+	cur := kfs.dir
+	segs := strings.Split(name, "/")
+	for i, seg := range segs {
+		k, ok := findKey(cur.keyList(), seg)
+		if !ok {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+
+		last := i == len(segs)-1
+		if last && !isDirClass(k.class) {
+			return &fsFile{key: k}, nil
+		}
+
+		if !isDirClass(k.class) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+
+		obj, err := cur.Get(seg)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		sub, ok := obj.(keyDir)
+		if !ok {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+
+		if last {
+			return &fsDir{name: name, entries: sub.keyList(), key: &k}, nil
+		}
+		cur = sub
+	}
+
+	// unreachable: segs is never empty (strings.Split(name, "/") on a
+	// fs.ValidPath name always yields at least one segment).
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func findKey(keys []Key, name string) (Key, bool) {
+	for _, k := range keys {
+		if k.name == name {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// fsFileInfo implements fs.FileInfo on top of a Key.
+type fsFileInfo struct {
+	key Key
+	dir bool
+}
+
+func (fi fsFileInfo) Name() string { return fi.key.name }
+func (fi fsFileInfo) Size() int64  { return int64(fi.key.objlen) }
+func (fi fsFileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+func (fi fsFileInfo) ModTime() time.Time { return fi.key.datetime }
+func (fi fsFileInfo) IsDir() bool        { return fi.dir }
+func (fi fsFileInfo) Sys() interface{}   { return fi.key }
+
+// fsFile exposes a single Key as a read-only fs.File.
+type fsFile struct {
+	key Key
+	r   io.ReadCloser
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return fsFileInfo{key: f.key}, nil }
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		r, err := f.key.Open()
+		if err != nil {
+			return 0, err
+		}
+		f.r = r
+	}
+	return f.r.Read(p)
+}
+
+func (f *fsFile) Close() error {
+	if f.r == nil {
+		return nil
+	}
+	return f.r.Close()
+}
+
+// fsDir exposes a directory key (or the root directory) as a
+// fs.ReadDirFile.
+type fsDir struct {
+	name    string
+	key     *Key
+	entries []Key
+	off     int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	if d.key == nil {
+		return fsFileInfo{key: Key{name: d.name}, dir: true}, nil
+	}
+	return fsFileInfo{key: *d.key, dir: true}, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *fsDir) Close() error { return nil }
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.entries)
+	} else {
+		if len(rest) > n {
+			rest = rest[:n]
+		} else if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		d.off += len(rest)
+	}
+
+	out := make([]fs.DirEntry, len(rest))
+	for i, k := range rest {
+		out[i] = fs.FileInfoToDirEntry(fsFileInfo{key: k, dir: isDirClass(k.class)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2017 The go-hep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rootio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/fs"
+	"sort"
+	"testing"
+)
+
+// fakeDir is a minimal keyDir double used to exercise keyFS's path
+// resolution without needing a real ROOT file on disk.
+type fakeDir struct {
+	keys []Key
+	subs map[string]*fakeDir
+}
+
+func (d *fakeDir) Class() string  { return "TDirectoryFile" }
+func (d *fakeDir) keyList() []Key { return d.keys }
+
+func (d *fakeDir) Get(namecycle string) (Object, error) {
+	if sub, ok := d.subs[namecycle]; ok {
+		return sub, nil
+	}
+	return nil, fmt.Errorf("fake: no such key %q", namecycle)
+}
+
+// newFakeTree builds:
+//
+//	leafA
+//	sub/
+//	  leafB
+//	  nested/
+//	    leafC
+func newFakeTree() *fakeDir {
+	nested := &fakeDir{keys: []Key{{name: "leafC", class: "TH1F"}}}
+
+	sub := &fakeDir{
+		keys: []Key{{name: "leafB", class: "TH1F"}, {name: "nested", class: "TDirectoryFile"}},
+		subs: map[string]*fakeDir{"nested": nested},
+	}
+
+	root := &fakeDir{
+		keys: []Key{{name: "leafA", class: "TH1F"}, {name: "sub", class: "TDirectoryFile"}},
+		subs: map[string]*fakeDir{"sub": sub},
+	}
+	return root
+}
+
+func TestKeyFSNestedOpen(t *testing.T) {
+	kfs := &keyFS{dir: newFakeTree()}
+
+	for _, tc := range []struct {
+		name  string
+		isDir bool
+	}{
+		{"leafA", false},
+		{"sub", true},
+		{"sub/leafB", false},
+		{"sub/nested", true},
+		{"sub/nested/leafC", false},
+	} {
+		f, err := kfs.Open(tc.name)
+		if err != nil {
+			t.Fatalf("Open(%q): %v", tc.name, err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatalf("Open(%q).Stat: %v", tc.name, err)
+		}
+		if fi.IsDir() != tc.isDir {
+			t.Errorf("Open(%q).Stat().IsDir() = %v, want %v", tc.name, fi.IsDir(), tc.isDir)
+		}
+	}
+}
+
+func TestKeyFSOpenMissing(t *testing.T) {
+	kfs := &keyFS{dir: newFakeTree()}
+	if _, err := kfs.Open("sub/nope"); err == nil {
+		t.Fatalf("Open(%q): got nil error, want ErrNotExist", "sub/nope")
+	}
+	if _, err := kfs.Open("leafA/nope"); err == nil {
+		t.Fatalf("Open(%q): got nil error, want ErrNotExist (leafA is not a directory)", "leafA/nope")
+	}
+}
+
+func TestKeyFSWalkDir(t *testing.T) {
+	kfs := &keyFS{dir: newFakeTree()}
+
+	var got []string
+	err := fs.WalkDir(kfs, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != "." {
+			got = append(got, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir: %v", err)
+	}
+
+	want := []string{"leafA", "sub", "sub/leafB", "sub/nested", "sub/nested/leafC"}
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Fatalf("WalkDir visited %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("WalkDir visited %v, want %v", got, want)
+		}
+	}
+}
+
+// TestKeyFSReadFile exercises the whole chain a real ROOT file's fs.FS
+// would: a leaf Key backed by a genuine zlib-compressed payload laid out
+// behind a real compression-record header (the format section.go
+// parses), read back through fs.ReadFile -> fsFile.Read -> Key.Open.
+// There is no checked-in ROOT file in this tree to test rootiofs
+// against (see rootiofs_test.go), so this is the closest available
+// substitute: real bytes and a real decompression path, just not a file
+// on disk.
+func TestKeyFSReadFile(t *testing.T) {
+	payload := []byte("the quick brown fox jumps over the lazy dog, 42 times")
+
+	var comp bytes.Buffer
+	w := zlib.NewWriter(&comp)
+	if _, err := w.Write(payload); err != nil {
+		t.Fatalf("zlib write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("zlib close: %v", err)
+	}
+
+	const fakeKeylen = 16
+	hdr := make([]byte, compressionHeaderLen)
+	hdr[0], hdr[1] = 'Z', 'L'
+	putROOTInt3(hdr[3:6], comp.Len())
+	putROOTInt3(hdr[6:9], len(payload))
+
+	var buf bytes.Buffer
+	buf.Write(make([]byte, fakeKeylen))
+	buf.Write(hdr)
+	buf.Write(comp.Bytes())
+
+	r := memReader{bytes.NewReader(buf.Bytes())}
+	file := &File{r: r, seeker: r, closer: r, end: int64(buf.Len())}
+
+	key := Key{
+		name:    "leaf",
+		class:   "TH1F",
+		seekkey: 0,
+		keylen:  fakeKeylen,
+		bytes:   int32(fakeKeylen + len(hdr) + comp.Len()),
+		objlen:  int32(len(payload)),
+	}
+	key.f = file
+
+	kfs := &keyFS{dir: &fakeDir{keys: []Key{key}}}
+
+	got, err := fs.ReadFile(kfs, "leaf")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("ReadFile(%q) = %q, want %q", "leaf", got, payload)
+	}
+}
+
+func TestKeyFSGlobNested(t *testing.T) {
+	kfs := &keyFS{dir: newFakeTree()}
+
+	got, err := fs.Glob(kfs, "sub/*")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	sort.Strings(got)
+	want := []string{"sub/leafB", "sub/nested"}
+	if len(got) != len(want) {
+		t.Fatalf("Glob(%q) = %v, want %v", "sub/*", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Glob(%q) = %v, want %v", "sub/*", got, want)
+		}
+	}
+}
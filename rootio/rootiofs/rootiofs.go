@@ -0,0 +1,23 @@
+// Copyright 2017 The go-hep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package rootiofs exposes the directory hierarchy of a ROOT file
+// through the standard io/fs interfaces, so that it can be walked with
+// fs.WalkDir, queried with fs.Glob, restricted with fs.Sub, or composed
+// with other Go tooling (embed, archive/tar, archive/zip, net/http) that
+// accepts a fs.FS.
+package rootiofs // import "go-hep.org/x/hep/rootio/rootiofs"
+
+import (
+	"io/fs"
+
+	"go-hep.org/x/hep/rootio"
+)
+
+// New returns a fs.FS view of f's directory hierarchy.
+//
+// It is a convenience wrapper around f.FS.
+func New(f *rootio.File) fs.FS {
+	return f.FS()
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 The go-hep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rootiofs_test
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+
+	"go-hep.org/x/hep/rootio"
+	"go-hep.org/x/hep/rootio/rootiofs"
+)
+
+// testdataFile is a small ROOT file that would normally be checked into
+// ../testdata, shared with the rest of the rootio test suite. No such
+// fixture exists in this checkout, so TestNewWalk skips rather than
+// failing every run; see rootio's TestKeyFSReadFile for coverage of the
+// same Open/Read/decompression path this package delegates to (f.FS()),
+// built against a synthetic but genuinely zlib-compressed payload
+// instead of a binary fixture.
+const testdataFile = "../testdata/small-flat-tree.root"
+
+func TestNewWalk(t *testing.T) {
+	if _, err := os.Stat(testdataFile); err != nil {
+		t.Skipf("no test file %q available: %v", testdataFile, err)
+	}
+
+	f, err := rootio.Open(testdataFile)
+	if err != nil {
+		t.Fatalf("could not open %q: %v", testdataFile, err)
+	}
+	defer f.Close()
+
+	fsys := rootiofs.New(f)
+
+	var names []string
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != "." {
+			names = append(names, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir: %v", err)
+	}
+	if len(names) == 0 {
+		t.Fatalf("WalkDir(%q) found no entries", testdataFile)
+	}
+
+	matches, err := fs.Glob(fsys, "*")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(matches) == 0 {
+		t.Fatalf("Glob(%q) found no entries", "*")
+	}
+
+	if _, err := fs.Stat(fsys, names[0]); err != nil {
+		t.Fatalf("Stat(%q): %v", names[0], err)
+	}
+}